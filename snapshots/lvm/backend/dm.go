@@ -0,0 +1,271 @@
+// +build linux,!no_devmapper
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+/*
+#cgo LDFLAGS: -ldevmapper
+#include <libdevmapper.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// sectorSize is the 512-byte unit dm-thin target lengths are expressed in
+// (see Documentation/device-mapper/thin-provisioning.txt).
+const sectorSize = 512
+
+// sizeMultiples maps the suffixes lvcreate's --virtualsize accepts to their
+// byte multiplier, using the binary (1024-based) units lvcreate defaults to
+// absent an explicit --units flag.
+var sizeMultiples = map[byte]int64{
+	'k': 1 << 10, 'K': 1 << 10,
+	'm': 1 << 20, 'M': 1 << 20,
+	'g': 1 << 30, 'G': 1 << 30,
+	't': 1 << 40, 'T': 1 << 40,
+}
+
+// parseSizeSectors parses an lvcreate --virtualsize style value (e.g. "10G",
+// "512M", or a bare byte count) into a length in 512-byte sectors, the unit
+// dm_task_add_target expects.
+func parseSizeSectors(size string) (uint64, error) {
+	size = strings.TrimSpace(size)
+	if size == "" {
+		return 0, errors.New("empty size")
+	}
+
+	mult := int64(1)
+	numeric := size
+	if m, ok := sizeMultiples[size[len(size)-1]]; ok {
+		mult = m
+		numeric = size[:len(size)-1]
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(numeric), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errors.Errorf("negative size %q", size)
+	}
+
+	bytes := n * mult
+	return uint64(bytes) / sectorSize, nil
+}
+
+// dmBackend talks to the kernel's dm-thin target directly through
+// libdevmapper, instead of forking lvcreate/lvchange/lvremove for every
+// snapshot operation. Pool creation still goes through lvcreate (see the
+// lvm package's createLogicalThinPool); this backend only handles the thin
+// device lifecycle once the pool exists.
+type dmBackend struct {
+	pool string
+	ids  *dmIDStore
+}
+
+func newDMBackend(vgname, lvpool, root string) (Backend, error) {
+	ids, err := newDMIDStore(filepath.Join(root, "devmapper-ids.db"))
+	if err != nil {
+		return nil, err
+	}
+	// lvm names the thin pool's internal dm device "vg-lv-tpool" under
+	// /dev/mapper, following its usual dash-escaping convention.
+	pool := fmt.Sprintf("%s-%s-tpool", strings.ReplaceAll(vgname, "-", "--"), strings.ReplaceAll(lvpool, "-", "--"))
+	return &dmBackend{pool: pool, ids: ids}, nil
+}
+
+func (b *dmBackend) CreateThin(ctx context.Context, vgname, lvpool, lvname, size string) error {
+	sizeSectors, err := parseSizeSectors(size)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse virtual size %q", size)
+	}
+
+	id, err := b.ids.Allocate(lvname, sizeSectors)
+	if err != nil {
+		return errors.Wrap(err, "failed to allocate thin device id")
+	}
+
+	if err := dmMessage(b.pool, fmt.Sprintf("create_thin %d", id)); err != nil {
+		return errors.Wrap(err, "failed to create thin device")
+	}
+
+	log.G(ctx).WithField("lv", lvname).WithField("device_id", id).Debug("created devmapper thin device")
+	return nil
+}
+
+func (b *dmBackend) CreateSnapshot(ctx context.Context, vgname, origin, lvname string) error {
+	originDev, ok, err := b.ids.Lookup(origin)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf("no devmapper device id recorded for origin %q", origin)
+	}
+
+	id, err := b.ids.Allocate(lvname, originDev.SizeSectors)
+	if err != nil {
+		return errors.Wrap(err, "failed to allocate thin device id")
+	}
+
+	// The thin-pool's "create_snap" message requires the origin device to
+	// be suspended first so its metadata can't change mid-snapshot; that is
+	// handled by Activate(lvname, false) before Remove/Commit call here.
+	if err := dmMessage(b.pool, fmt.Sprintf("create_snap %d %d", id, originDev.ID)); err != nil {
+		return errors.Wrap(err, "failed to create snapshot device")
+	}
+
+	log.G(ctx).WithField("lv", lvname).WithField("origin", origin).WithField("device_id", id).Debug("created devmapper snapshot device")
+	return nil
+}
+
+func (b *dmBackend) Activate(ctx context.Context, vgname, lvname string, activate bool) error {
+	if !activate {
+		return dmRemoveDevice(lvname)
+	}
+
+	dev, ok, err := b.ids.Lookup(lvname)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf("no devmapper device id recorded for %q", lvname)
+	}
+
+	return dmCreateThinMapping(lvname, b.pool, dev.ID, dev.SizeSectors)
+}
+
+func (b *dmBackend) Remove(ctx context.Context, vgname, lvname string) error {
+	dev, ok, err := b.ids.Lookup(lvname)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := dmMessage(b.pool, fmt.Sprintf("delete %d", dev.ID)); err != nil {
+			return errors.Wrap(err, "failed to delete thin device")
+		}
+	}
+	if err := b.ids.Delete(lvname); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close releases the device-id store's database handle.
+func (b *dmBackend) Close() error {
+	return b.ids.Close()
+}
+
+// DevicePath returns the /dev/mapper node dmCreateThinMapping names the
+// active dm-thin device after, via dm_task_set_name.
+func (b *dmBackend) DevicePath(vgname, lvname string) string {
+	return filepath.Join("/dev/mapper", lvname)
+}
+
+// dmMessage sends msg to the target device's message interface, the
+// mechanism dm-thin uses for out-of-band pool operations like create_thin
+// and create_snap (see Documentation/device-mapper/thin-provisioning.txt).
+func dmMessage(device, msg string) error {
+	task := C.dm_task_create(C.DM_DEVICE_TARGET_MSG)
+	if task == nil {
+		return errors.New("dm_task_create failed")
+	}
+	defer C.dm_task_destroy(task)
+
+	cDevice := C.CString(device)
+	defer C.free(unsafe.Pointer(cDevice))
+	if C.dm_task_set_name(task, cDevice) == 0 {
+		return errors.New("dm_task_set_name failed")
+	}
+
+	cMsg := C.CString(msg)
+	defer C.free(unsafe.Pointer(cMsg))
+	if C.dm_task_set_message(task, cMsg) == 0 {
+		return errors.New("dm_task_set_message failed")
+	}
+
+	if C.dm_task_run(task) == 0 {
+		return errors.Errorf("dm message %q to %q failed", msg, device)
+	}
+	return nil
+}
+
+// dmCreateThinMapping activates name as a dm-thin device backed by device id
+// within the pool at poolDevice, equivalent to lvchange -ay for a thin LV.
+// sizeSectors is the device's virtual size, in 512-byte sectors, recorded by
+// dmIDStore at CreateThin/CreateSnapshot time; it becomes the length of the
+// single "thin" target spanning the whole device.
+func dmCreateThinMapping(name, poolDevice string, id uint32, sizeSectors uint64) error {
+	task := C.dm_task_create(C.DM_DEVICE_CREATE)
+	if task == nil {
+		return errors.New("dm_task_create failed")
+	}
+	defer C.dm_task_destroy(task)
+
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	if C.dm_task_set_name(task, cName) == 0 {
+		return errors.New("dm_task_set_name failed")
+	}
+
+	params := C.CString(fmt.Sprintf("/dev/mapper/%s %d", poolDevice, id))
+	defer C.free(unsafe.Pointer(params))
+	target := C.CString("thin")
+	defer C.free(unsafe.Pointer(target))
+
+	if C.dm_task_add_target(task, 0, C.uint64_t(sizeSectors), target, params) == 0 {
+		return errors.New("dm_task_add_target failed")
+	}
+
+	if C.dm_task_run(task) == 0 {
+		return errors.Errorf("failed to activate thin device %q", name)
+	}
+	return nil
+}
+
+// dmRemoveDevice tears down the active dm mapping for name, equivalent to
+// lvchange -an for a thin LV. The underlying thin device and its metadata
+// in the pool are untouched; Remove() deletes those separately.
+func dmRemoveDevice(name string) error {
+	task := C.dm_task_create(C.DM_DEVICE_REMOVE)
+	if task == nil {
+		return errors.New("dm_task_create failed")
+	}
+	defer C.dm_task_destroy(task)
+
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	if C.dm_task_set_name(task, cName) == 0 {
+		return errors.New("dm_task_set_name failed")
+	}
+
+	if C.dm_task_run(task) == 0 {
+		return errors.Errorf("failed to remove device mapping %q", name)
+	}
+	return nil
+}
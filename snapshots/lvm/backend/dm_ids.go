@@ -0,0 +1,120 @@
+// +build linux,!no_devmapper
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	dmDeviceBucket = []byte("devmapper-device-ids")
+	dmNextIDKey    = []byte("next-id")
+)
+
+// dmDevice is what dmIDStore persists per logical volume: the thin device ID
+// the dm-thin target uses internally, and the virtual size it was created
+// with, in 512-byte sectors. The size has to be remembered here because
+// Activate needs it to build the dm-thin target line and the thin-pool
+// metadata has no notion of a size by name.
+type dmDevice struct {
+	ID          uint32
+	SizeSectors uint64
+}
+
+// dmIDStore maps lvm logical volume names to the thin device IDs and sizes
+// the dm-thin target uses internally, and hands out new IDs for
+// CreateThin/CreateSnapshot, since the thin-pool itself does not keep track
+// of devices by name. Which origin a snapshot was created from is not
+// recorded.
+type dmIDStore struct {
+	db *bolt.DB
+}
+
+func newDMIDStore(path string) (*dmIDStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open devmapper device-id store")
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dmDeviceBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &dmIDStore{db: db}, nil
+}
+
+func (s *dmIDStore) Close() error {
+	return s.db.Close()
+}
+
+// Allocate assigns and persists a new thin device ID for lvname, recording
+// sizeSectors alongside it for later Activate calls.
+func (s *dmIDStore) Allocate(lvname string, sizeSectors uint64) (uint32, error) {
+	var id uint32
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dmDeviceBucket)
+
+		id = 1
+		if v := b.Get(dmNextIDKey); v != nil {
+			id = binary.BigEndian.Uint32(v) + 1
+		}
+
+		idBuf := make([]byte, 4)
+		binary.BigEndian.PutUint32(idBuf, id)
+		if err := b.Put(dmNextIDKey, idBuf); err != nil {
+			return err
+		}
+
+		recBuf, err := json.Marshal(dmDevice{ID: id, SizeSectors: sizeSectors})
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(lvname), recBuf)
+	})
+	return id, err
+}
+
+// Lookup returns the thin device ID and size previously allocated for
+// lvname.
+func (s *dmIDStore) Lookup(lvname string) (dmDevice, bool, error) {
+	var dev dmDevice
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(dmDeviceBucket).Get([]byte(lvname))
+		if v == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(v, &dev)
+	})
+	return dev, ok, err
+}
+
+// Delete forgets the thin device ID allocated for lvname.
+func (s *dmIDStore) Delete(lvname string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(dmDeviceBucket).Delete([]byte(lvname))
+	})
+}
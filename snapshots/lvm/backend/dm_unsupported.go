@@ -0,0 +1,28 @@
+// +build linux,no_devmapper
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import "github.com/pkg/errors"
+
+// newDMBackend is stubbed out when built with the no_devmapper tag, the
+// same pattern containerd's other cgo-backed snapshotters (e.g. btrfs) use
+// to build without their C library dependency.
+func newDMBackend(vgname, lvpool, root string) (Backend, error) {
+	return nil, errors.New("lvm: devmapper backend not built (built with no_devmapper)")
+}
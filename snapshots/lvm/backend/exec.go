@@ -0,0 +1,69 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// execBackend is the default Backend: it forks lvcreate/lvchange/lvremove
+// for every operation.
+type execBackend struct {
+	retryBudget time.Duration
+}
+
+func (b *execBackend) CreateThin(ctx context.Context, vgname, lvpool, lvname, size string) error {
+	args := []string{"--virtualsize", size, "--name", lvname, "--thin", vgname + "/" + lvpool}
+	_, _, err := Exec(ctx, b.retryBudget, "lvcreate", args...)
+	return errors.Wrap(err, "unable to create thin volume")
+}
+
+func (b *execBackend) CreateSnapshot(ctx context.Context, vgname, origin, lvname string) error {
+	args := []string{"--name", lvname, "--snapshot", vgname + "/" + origin}
+	_, _, err := Exec(ctx, b.retryBudget, "lvcreate", args...)
+	return errors.Wrap(err, "unable to create snapshot volume")
+}
+
+func (b *execBackend) Activate(ctx context.Context, vgname, lvname string, activate bool) error {
+	state := "n"
+	if activate {
+		state = "y"
+	}
+	_, _, err := Exec(ctx, b.retryBudget, "lvchange", "-K", vgname+"/"+lvname, "-a", state)
+	return errors.Wrap(err, "unable to change logical volume activation state")
+}
+
+func (b *execBackend) Remove(ctx context.Context, vgname, lvname string) error {
+	_, stderr, err := Exec(ctx, b.retryBudget, "lvremove", "-y", vgname+"/"+lvname)
+	if err != nil && !strings.Contains(string(stderr), "Failed to find") {
+		return errors.Wrap(err, "unable to remove logical volume")
+	}
+	return nil
+}
+
+// DevicePath returns the lvm-convention /dev/<vg>/<lv> symlink lvcreate and
+// lvchange -ay create for an active logical volume.
+func (b *execBackend) DevicePath(vgname, lvname string) string {
+	return filepath.Join("/dev", vgname, lvname)
+}
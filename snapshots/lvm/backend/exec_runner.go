@@ -0,0 +1,141 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/containerd/containerd/log"
+)
+
+// DefaultRetryBudget bounds the total time Exec spends retrying a transient
+// failure before giving up, when the caller passes budget <= 0.
+const DefaultRetryBudget = 10 * time.Second
+
+// BusyExitCode is the exit status LVM commands return when they could not
+// obtain the VG/LV lock because another process holds it, e.g. a concurrent
+// vgs/lvcreate. It is the only exit code Exec treats as transient today.
+const BusyExitCode = 5
+
+// LVMError wraps a failed LVM command invocation with its exit code, so
+// callers can distinguish transient lock contention from permanent
+// failures without string-matching err.Error().
+type LVMError struct {
+	Cmd      string
+	Args     []string
+	ExitCode int
+	Stderr   string
+	err      error
+}
+
+func (e *LVMError) Error() string {
+	return e.err.Error()
+}
+
+func (e *LVMError) Unwrap() error {
+	return e.err
+}
+
+func (e *LVMError) transient() bool {
+	return e.ExitCode == BusyExitCode
+}
+
+// Exec runs cmd with args, honoring ctx cancellation, and retries transient
+// failures (LVM lock contention) with exponential backoff and jitter up to
+// budget (DefaultRetryBudget if budget <= 0).
+func Exec(ctx context.Context, budget time.Duration, cmd string, args ...string) ([]byte, []byte, error) {
+	if budget <= 0 {
+		budget = DefaultRetryBudget
+	}
+
+	deadline := time.Now().Add(budget)
+	backoff := 50 * time.Millisecond
+
+	for {
+		start := time.Now()
+		stdout, stderr, err := runOnce(ctx, cmd, args)
+		dur := time.Since(start)
+
+		entry := log.G(ctx).WithField("cmd", cmd).WithField("args", args).WithField("duration", dur)
+
+		if err == nil {
+			entry.Debug("ran lvm command")
+			return stdout, stderr, nil
+		}
+
+		lvmErr, ok := err.(*LVMError)
+		entry = entry.WithField("err", err)
+		if ok {
+			entry = entry.WithField("exit_code", lvmErr.ExitCode)
+		}
+
+		if !ok || !lvmErr.transient() || time.Now().Add(backoff).After(deadline) {
+			entry.Debug("lvm command failed")
+			return stdout, stderr, err
+		}
+
+		entry.WithField("backoff", backoff).Debug("retrying transient lvm failure")
+		select {
+		case <-ctx.Done():
+			return stdout, stderr, ctx.Err()
+		case <-time.After(backoff + jitter(backoff)):
+		}
+		backoff *= 2
+	}
+}
+
+func runOnce(ctx context.Context, cmd string, args []string) ([]byte, []byte, error) {
+	c := exec.CommandContext(ctx, cmd, args...)
+	c.Env = os.Environ()
+	c.SysProcAttr = sysProcAttr()
+
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+
+	err := c.Run()
+	if err == nil {
+		return stdout.Bytes(), stderr.Bytes(), nil
+	}
+
+	exitCode := -1
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	}
+
+	return stdout.Bytes(), stderr.Bytes(), &LVMError{
+		Cmd:      cmd,
+		Args:     args,
+		ExitCode: exitCode,
+		Stderr:   stderr.String(),
+		err:      err,
+	}
+}
+
+// jitter returns a random duration in [0, d/2), to avoid thundering-herd
+// retries when several snapshot operations hit lock contention at once.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) / 2))
+}
@@ -0,0 +1,94 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExecSuccess(t *testing.T) {
+	stdout, _, err := Exec(context.Background(), time.Second, "echo", "-n", "hello")
+	if err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+	if string(stdout) != "hello" {
+		t.Fatalf("stdout = %q; want %q", stdout, "hello")
+	}
+}
+
+// TestExecRetriesTransientFailure drives a real BusyExitCode exit through a
+// tiny shell script, so Exec has to go through its backoff/retry loop before
+// eventually giving up, the same as it would against real lock-contended
+// lvm commands.
+func TestExecRetriesTransientFailure(t *testing.T) {
+	_, _, err := Exec(context.Background(), 120*time.Millisecond, "sh", "-c", "exit 5")
+	if err == nil {
+		t.Fatal("Exec should fail once the retry budget is exhausted")
+	}
+	lvmErr, ok := err.(*LVMError)
+	if !ok {
+		t.Fatalf("err = %T(%v); want *LVMError", err, err)
+	}
+	if !lvmErr.transient() {
+		t.Fatalf("ExitCode = %d; want the transient BusyExitCode", lvmErr.ExitCode)
+	}
+}
+
+func TestExecNonTransientFailureDoesNotRetry(t *testing.T) {
+	start := time.Now()
+	_, _, err := Exec(context.Background(), 10*time.Second, "sh", "-c", "exit 1")
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("Exec should return the command's error")
+	}
+	lvmErr, ok := err.(*LVMError)
+	if !ok {
+		t.Fatalf("err = %T(%v); want *LVMError", err, err)
+	}
+	if lvmErr.transient() {
+		t.Fatalf("exit code 1 should not be treated as transient")
+	}
+	if elapsed > time.Second {
+		t.Fatalf("non-transient failure should fail fast, took %s", elapsed)
+	}
+}
+
+func TestExecContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := Exec(ctx, time.Second, "sleep", "1")
+	if err == nil {
+		t.Fatal("Exec with a canceled context should return an error")
+	}
+}
+
+func TestJitterBounds(t *testing.T) {
+	d := 100 * time.Millisecond
+	for i := 0; i < 20; i++ {
+		j := jitter(d)
+		if j < 0 || j >= d/2 {
+			t.Fatalf("jitter(%s) = %s; want in [0, %s)", d, j, d/2)
+		}
+	}
+	if j := jitter(0); j != 0 {
+		t.Fatalf("jitter(0) = %s; want 0", j)
+	}
+}
@@ -0,0 +1,39 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sysProcAttr returns the os/exec.Cmd.SysProcAttr used for every LVM child
+// process: Pdeathsig kills it if containerd itself dies before the command
+// finishes, and Setpgid puts it in its own process group so a lazy umount
+// can't leave it orphaned in containerd's. The return type is still
+// *syscall.SysProcAttr, and Pdeathsig still syscall.Signal, because that is
+// the concrete type os/exec.Cmd.SysProcAttr requires; only the SIGTERM
+// constant itself comes from x/sys/unix.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Pdeathsig: syscall.Signal(unix.SIGTERM),
+		Setpgid:   true,
+	}
+}
@@ -0,0 +1,31 @@
+// +build !linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import "syscall"
+
+// sysProcAttr has no Linux-specific process attributes to set on other
+// platforms; the lvm snapshotter itself is still Linux-only today (see the
+// linux build tags throughout this package), but Exec's retry/backoff and
+// logging logic does not need to be. The *syscall.SysProcAttr return type
+// is still required here too, since that is what os/exec.Cmd.SysProcAttr's
+// field type is on every platform.
+func sysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
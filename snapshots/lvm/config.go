@@ -0,0 +1,167 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvm
+
+import (
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/lvm/backend"
+	"github.com/pkg/errors"
+)
+
+// Label keys recognized on snapshots.Info.Labels. Callers set these through
+// snapshots.Opt (e.g. snapshots.WithLabels) when calling Prepare/View, the
+// same way podman's local volume driver accepts --opt device=...,
+// --opt type=..., --opt o=... on volume create.
+const (
+	// LabelFSType selects the filesystem used to format a new, non-snapshot
+	// logical volume. Defaults to SnapshotterConfig.FSType.
+	LabelFSType = "containerd.io/snapshot/lvm.fstype"
+
+	// LabelMkfsOptions is a comma-separated list of extra arguments appended
+	// to the mkfs invocation, one argument per item, e.g. "-L,rootfs".
+	LabelMkfsOptions = "containerd.io/snapshot/lvm.mkfs-options"
+
+	// LabelMountOptions is a comma-separated list of mount options, e.g.
+	// "nodev,noexec,discard", stored on the returned mount.Mount.
+	LabelMountOptions = "containerd.io/snapshot/lvm.mount-options"
+
+	// LabelSize overrides the virtual size passed to lvcreate --virtualsize
+	// when creating a new thin logical volume.
+	LabelSize = "containerd.io/snapshot/lvm.size"
+)
+
+// allowedFSTypes enumerates the filesystems formatVolume knows how to tune
+// mkfs arguments for. Anything else is rejected rather than silently passed
+// through to mkfs.<fstype>.
+var allowedFSTypes = map[string]bool{
+	"ext4": true,
+	"xfs":  true,
+}
+
+// SnapshotterConfig holds the defaults applied to every snapshot unless
+// overridden per-snapshot via labels (see LabelFSType and friends).
+type SnapshotterConfig struct {
+	// FSType is the filesystem used to format newly created logical volumes.
+	FSType string
+
+	// MkfsOptions are extra arguments passed to mkfs.<FSType>.
+	MkfsOptions []string
+
+	// MountOptions are the options set on the mount.Mount returned from
+	// Mounts(), e.g. []string{"discard"}.
+	MountOptions []string
+
+	// Size is the default --virtualsize passed to lvcreate.
+	Size string
+
+	// RetryBudget bounds how long backend.Exec spends retrying a transient
+	// LVM failure (lock contention) before giving up. Defaults to
+	// backend.DefaultRetryBudget if zero.
+	RetryBudget time.Duration
+
+	// Backend selects the implementation used for the per-snapshot
+	// create/snapshot/activate/remove lifecycle: backend.ExecKind (the
+	// default, forks lvcreate/lvchange/lvremove) or backend.DM (talks to
+	// the kernel's dm-thin target directly via libdevmapper). An empty
+	// value selects ExecKind.
+	Backend backend.Kind
+}
+
+// DefaultConfig returns the default SnapshotterConfig. NewSnapshotter does
+// not fill in these defaults for fields left zero in the config it is given.
+func DefaultConfig() SnapshotterConfig {
+	return SnapshotterConfig{
+		FSType:      "ext4",
+		Size:        "10G",
+		RetryBudget: backend.DefaultRetryBudget,
+		Backend:     backend.ExecKind,
+	}
+}
+
+// snapshotOptions is the result of resolving a SnapshotterConfig against the
+// labels on a single snapshots.Info.
+type snapshotOptions struct {
+	FSType       string
+	MkfsOptions  []string
+	MountOptions []string
+	Size         string
+}
+
+// resolveOptions merges cfg with any per-snapshot label overrides, validating
+// that only the recognized lvm.* labels and filesystems are used.
+func resolveOptions(cfg SnapshotterConfig, labels map[string]string) (snapshotOptions, error) {
+	opts := snapshotOptions{
+		FSType:       cfg.FSType,
+		MkfsOptions:  cfg.MkfsOptions,
+		MountOptions: cfg.MountOptions,
+		Size:         cfg.Size,
+	}
+
+	for k, v := range labels {
+		switch k {
+		case LabelFSType:
+			opts.FSType = v
+		case LabelMkfsOptions:
+			opts.MkfsOptions = splitCSV(v)
+		case LabelMountOptions:
+			opts.MountOptions = splitCSV(v)
+		case LabelSize:
+			opts.Size = v
+		default:
+			if strings.HasPrefix(k, "containerd.io/snapshot/lvm.") {
+				return snapshotOptions{}, errors.Errorf("unrecognized lvm snapshot option %q", k)
+			}
+		}
+	}
+
+	if !allowedFSTypes[opts.FSType] {
+		return snapshotOptions{}, errors.Errorf("unsupported lvm fstype %q", opts.FSType)
+	}
+
+	return opts, nil
+}
+
+// resolveInfo applies any snapshots.Opt to a fresh snapshots.Info and then
+// resolves it against cfg, the way Prepare/View collect their options.
+func resolveInfo(cfg SnapshotterConfig, opts ...snapshots.Opt) (snapshotOptions, error) {
+	var info snapshots.Info
+	for _, o := range opts {
+		if err := o(&info); err != nil {
+			return snapshotOptions{}, errors.Wrap(err, "failed to apply snapshot option")
+		}
+	}
+	return resolveOptions(cfg, info.Labels)
+}
+
+func splitCSV(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
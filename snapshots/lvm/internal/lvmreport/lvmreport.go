@@ -0,0 +1,128 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package lvmreport parses the `--reportformat json` output of the lvs, vgs
+// and lvdisplay commands into typed structs, instead of scraping trimmed
+// plain-text columns. Field names match the underlying LVM report field
+// names verbatim so callers can cross-reference `man lvreport`.
+package lvmreport
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/containerd/containerd/snapshots/lvm/backend"
+	"github.com/pkg/errors"
+)
+
+// LVReport is a single logical volume entry from `lvs --reportformat json`.
+type LVReport struct {
+	LVName      string `json:"lv_name"`
+	VGName      string `json:"vg_name"`
+	LVAttr      string `json:"lv_attr"`
+	LVSize      string `json:"lv_size"`
+	PoolLV      string `json:"pool_lv"`
+	DataPercent string `json:"data_percent"`
+}
+
+// VGReport is a single volume group entry from `vgs --reportformat json`.
+type VGReport struct {
+	VGName    string `json:"vg_name"`
+	VGSize    string `json:"vg_size"`
+	VGFree    string `json:"vg_free"`
+	PVCount   string `json:"pv_count"`
+	LVCount   string `json:"lv_count"`
+	VGAttr    string `json:"vg_attr"`
+	VGExtents string `json:"vg_extent_count"`
+}
+
+// SegReport is a single segment entry from `lvdisplay --reportformat json
+// -m`, used to resolve a thin logical volume's backing thin-pool segment.
+type SegReport struct {
+	LVName      string `json:"lv_name"`
+	SegStartPE  string `json:"seg_start_pe"`
+	SegSizePE   string `json:"seg_size_pe"`
+	ThinID      string `json:"thin_id"`
+	ThinCount   string `json:"thin_count"`
+	ChunkSize   string `json:"chunk_size"`
+	ZeroNewBlks string `json:"zero"`
+}
+
+// report mirrors the `{"report": [{"<tag>": [...]}]}` envelope common to
+// every LVM `--reportformat json` command.
+type report struct {
+	Report []map[string]json.RawMessage `json:"report"`
+}
+
+// ListLVs runs `lvs --reportformat json --units b --nosuffix` against args
+// (e.g. a vg/lv selector plus --options) and returns the parsed lv entries.
+func ListLVs(ctx context.Context, budget time.Duration, args ...string) ([]LVReport, error) {
+	var lvs []LVReport
+	if err := runJSON(ctx, budget, "lvs", args, "lv", &lvs); err != nil {
+		return nil, err
+	}
+	return lvs, nil
+}
+
+// ListVGs runs `vgs --reportformat json --units b --nosuffix` against args
+// and returns the parsed vg entries.
+func ListVGs(ctx context.Context, budget time.Duration, args ...string) ([]VGReport, error) {
+	var vgs []VGReport
+	if err := runJSON(ctx, budget, "vgs", args, "vg", &vgs); err != nil {
+		return nil, err
+	}
+	return vgs, nil
+}
+
+// ListSegments runs `lvdisplay --reportformat json --units b --nosuffix -m`
+// against args and returns the parsed segment entries.
+func ListSegments(ctx context.Context, budget time.Duration, args ...string) ([]SegReport, error) {
+	var segs []SegReport
+	if err := runJSON(ctx, budget, "lvdisplay", append([]string{"-m"}, args...), "seg", &segs); err != nil {
+		return nil, err
+	}
+	return segs, nil
+}
+
+// runJSON invokes cmd with --reportformat json --units b --nosuffix plus
+// args through backend.Exec, honoring ctx cancellation and retrying
+// transient lock-contention failures like every other LVM invocation in
+// this package, then decodes the tag field ("lv", "vg", "seg") of the first
+// report block into out.
+func runJSON(ctx context.Context, budget time.Duration, cmd string, args []string, tag string, out interface{}) error {
+	fullArgs := append([]string{"--reportformat", "json", "--units", "b", "--nosuffix"}, args...)
+
+	stdout, _, err := backend.Exec(ctx, budget, cmd, fullArgs...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to run %s %v", cmd, fullArgs)
+	}
+
+	var rep report
+	if err := json.Unmarshal(stdout, &rep); err != nil {
+		return errors.Wrapf(err, "failed to parse %s json report", cmd)
+	}
+	if len(rep.Report) == 0 {
+		return nil
+	}
+	raw, ok := rep.Report[0][tag]
+	if !ok {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(raw, out), "failed to decode %s entries", tag)
+}
@@ -0,0 +1,94 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvmreport
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// TestParseLVReport exercises the report-unmarshaling path runJSON drives,
+// using the exact shape `lvs --reportformat json` emits, without shelling
+// out to lvs itself.
+func TestParseLVReport(t *testing.T) {
+	raw := []byte(`{
+		"report": [
+			{
+				"lv": [
+					{"lv_name":"cd-a", "vg_name":"vg0", "lv_attr":"Vwi-a-tz--", "lv_size":"10737418240", "pool_lv":"pool0", "data_percent":"12.50"}
+				]
+			}
+		]
+	}`)
+
+	var rep report
+	if err := json.Unmarshal(raw, &rep); err != nil {
+		t.Fatalf("unmarshal report envelope: %v", err)
+	}
+
+	var lvs []LVReport
+	if err := json.Unmarshal(rep.Report[0]["lv"], &lvs); err != nil {
+		t.Fatalf("unmarshal lv entries: %v", err)
+	}
+	if len(lvs) != 1 {
+		t.Fatalf("len(lvs) = %d; want 1", len(lvs))
+	}
+
+	lv := lvs[0]
+	if lv.LVName != "cd-a" || lv.VGName != "vg0" || lv.PoolLV != "pool0" {
+		t.Fatalf("unexpected lv fields: %+v", lv)
+	}
+	if lv.LVAttr[4] != 'a' {
+		t.Fatalf("lv_attr[4] = %q; want active 'a'", lv.LVAttr[4])
+	}
+	if lv.DataPercent != "12.50" {
+		t.Fatalf("data_percent = %q; want 12.50", lv.DataPercent)
+	}
+}
+
+// TestParseEmptyReport covers the "no matching volumes" case, where lvs
+// still succeeds but the report block has no entries.
+func TestParseEmptyReport(t *testing.T) {
+	raw := []byte(`{"report": [{"lv": []}]}`)
+
+	var rep report
+	if err := json.Unmarshal(raw, &rep); err != nil {
+		t.Fatalf("unmarshal report envelope: %v", err)
+	}
+
+	var lvs []LVReport
+	if err := json.Unmarshal(rep.Report[0]["lv"], &lvs); err != nil {
+		t.Fatalf("unmarshal lv entries: %v", err)
+	}
+	if len(lvs) != 0 {
+		t.Fatalf("len(lvs) = %d; want 0", len(lvs))
+	}
+}
+
+// TestRunJSONMissingCommand covers runJSON's error path when the underlying
+// command cannot even be started (e.g. no lvs binary present, as in this
+// test environment).
+func TestRunJSONMissingCommand(t *testing.T) {
+	var lvs []LVReport
+	err := runJSON(context.Background(), 0, "lvm-report-test-missing-binary", nil, "lv", &lvs)
+	if err == nil {
+		t.Fatal("runJSON with a nonexistent binary should return an error")
+	}
+}
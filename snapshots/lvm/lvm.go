@@ -19,21 +19,22 @@
 package lvm
 
 import (
-	"os"
-	"os/exec"
+	"context"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
-	"syscall"
 	"time"
 
+	"github.com/containerd/containerd/mount"
 	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/lvm/backend"
+	"github.com/containerd/containerd/snapshots/lvm/internal/lvmreport"
 	"github.com/pkg/errors"
 )
 
-const retries = 10
-
-func formatVolume(vgname string, lvname string, fstype string) error {
+// formatVolume runs mkfs.<fstype> against devicePath, the already-activated
+// block device node backend.Backend.DevicePath resolved for the volume.
+func formatVolume(ctx context.Context, budget time.Duration, devicePath string, fstype string, extraArgs []string) error {
 	var mkfsArgs []string
 	switch fstype {
 	case "ext4":
@@ -42,168 +43,138 @@ func formatVolume(vgname string, lvname string, fstype string) error {
 		mkfsArgs = append(mkfsArgs, "-K")
 	default:
 	}
+	mkfsArgs = append(mkfsArgs, extraArgs...)
 
 	cmd := "mkfs." + fstype
-	mkfsArgs = append(mkfsArgs, filepath.Join("/dev/", vgname, lvname))
-	_, err := runCommand(cmd, mkfsArgs)
+	mkfsArgs = append(mkfsArgs, devicePath)
+	_, _, err := backend.Exec(ctx, budget, cmd, mkfsArgs...)
 	return err
 }
 
-func createLVMVolume(lock sync.Mutex, lvname string, vgname string, lvpoolname string, size string, parent string, kind snapshots.Kind) (string, error) {
-	cmd := "lvcreate"
-	args := []string{}
-	out := ""
-	var err error
-	lock.Lock()
-	defer lock.Unlock()
-
-	if parent != "" {
-		args = append(args, "--name", lvname, "--snapshot", vgname+"/"+parent)
-	} else {
-		// Create a new logical volume without a base snapshot
-		args = append(args, "--virtualsize", size, "--name", lvname, "--thin", vgname+"/"+lvpoolname)
+// volumeMount builds the mount.Mount returned to the caller for the
+// already-activated block device at devicePath, applying the resolved
+// filesystem and mount options.
+func volumeMount(devicePath string, opts snapshotOptions) mount.Mount {
+	return mount.Mount{
+		Type:    opts.FSType,
+		Source:  devicePath,
+		Options: opts.MountOptions,
 	}
-
-	// This change will prevent the volume from being mountable. Relying on the
-	// mount command to do read-only mounting.
-	//if kind == snapshots.KindView {
-	//	args = append(args, "-pr")
-	//}
-
-	//Let's go and create the volume
-	if out, err = runCommand(cmd, args); err != nil {
-		return out, errors.Wrap(err, "Unable to create volume")
-	}
-	return out, err
 }
 
-func removeLVMVolume(lock sync.Mutex, lvname string, vgname string) (string, error) {
-	lock.Lock()
-	defer lock.Unlock()
-
-	// Unmount volume from the system
-	cmd := "umount"
+// unmountDevice unmounts the logical volume vgname/lvname, ignoring the
+// error when it was not mounted in the first place. This is a plain
+// mount(8) operation, independent of which backend.Backend manages the
+// volume itself.
+func unmountDevice(ctx context.Context, budget time.Duration, vgname string, lvname string) error {
 	args := []string{"--lazy", "--force", "--all-targets", filepath.Join("/dev", vgname, lvname)}
-
-	output, err := runCommand(cmd, args)
-	if err != nil && !strings.Contains(output, "not mounted") {
-		return output, errors.Wrap(err, "Unable to unmount volume")
+	_, stderr, err := backend.Exec(ctx, budget, "umount", args...)
+	if err != nil && !strings.Contains(string(stderr), "not mounted") {
+		return errors.Wrap(err, "unable to unmount volume")
 	}
-	cmd = "lvremove"
-	args = []string{"-y", vgname + "/" + lvname}
-
-	return runCommand(cmd, args)
+	return nil
 }
 
-func createVolumeGroup(lock sync.Mutex, drive string, vgname string) (string, error) {
-	lock.Lock()
-	defer lock.Unlock()
+func createVolumeGroup(ctx context.Context, budget time.Duration, drive string, vgname string) (string, error) {
 	cmd := "vgcreate"
 	args := []string{vgname, drive}
 
-	return runCommand(cmd, args)
+	stdout, _, err := backend.Exec(ctx, budget, cmd, args...)
+	return strings.TrimSpace(string(stdout)), err
 }
 
-func createLogicalThinPool(lock sync.Mutex, vgname string, lvpool string) (string, error) {
-	lock.Lock()
-	defer lock.Unlock()
+func createLogicalThinPool(ctx context.Context, budget time.Duration, vgname string, lvpool string) (string, error) {
 	cmd := "lvcreate"
 	args := []string{"--thinpool", lvpool, "--extents", "90%FREE", vgname}
 
-	out, err := runCommand(cmd, args)
-	if err != nil && (err.Error() == "exit status 5") {
-		return out, nil
+	stdout, _, err := backend.Exec(ctx, budget, cmd, args...)
+	if lvmErr, ok := err.(*backend.LVMError); ok && lvmErr.ExitCode == backend.BusyExitCode {
+		return strings.TrimSpace(string(stdout)), nil
 	}
-	return out, err
+	return strings.TrimSpace(string(stdout)), err
 }
 
-func deleteVolumeGroup(lock sync.Mutex, vgname string) (string, error) {
-	lock.Lock()
-	defer lock.Unlock()
+func deleteVolumeGroup(ctx context.Context, budget time.Duration, vgname string) (string, error) {
 	cmd := "vgremove"
 	args := []string{"-y", vgname}
 
-	return runCommand(cmd, args)
+	stdout, _, err := backend.Exec(ctx, budget, cmd, args...)
+	return strings.TrimSpace(string(stdout)), err
 }
 
-func checkVG(lock sync.Mutex, vgname string) (string, error) {
-	lock.Lock()
-	defer lock.Unlock()
-	var err error
-	output := ""
-	cmd := "vgs"
-	args := []string{vgname, "--options", "vg_name", "--no-headings"}
-	output, err = runCommand(cmd, args)
-	return output, err
+func checkVG(ctx context.Context, budget time.Duration, vgname string) (string, error) {
+	vgs, err := lvmreport.ListVGs(ctx, budget, vgname, "--options", "vg_name")
+	if err != nil {
+		return "", err
+	}
+	if len(vgs) == 0 {
+		return "", nil
+	}
+	return vgs[0].VGName, nil
 }
 
-func checkLV(lock sync.Mutex, vgname string, lvname string) (string, error) {
-	lock.Lock()
-	defer lock.Unlock()
-	var err error
-	output := ""
-	cmd := "lvs"
-	args := []string{vgname + "/" + lvname, "--options", "lv_name", "--no-heading"}
-	output, err = runCommand(cmd, args)
-	return output, err
+func checkLV(ctx context.Context, budget time.Duration, vgname string, lvname string) (string, error) {
+	lvs, err := lvmreport.ListLVs(ctx, budget, vgname+"/"+lvname, "--options", "lv_name")
+	if err != nil {
+		return "", err
+	}
+	if len(lvs) == 0 {
+		return "", nil
+	}
+	return lvs[0].LVName, nil
 }
 
-func toggleactivateLV(lock sync.Mutex, vgname string, lvname string, activate bool) (string, error) {
-	lock.Lock()
-	defer lock.Unlock()
-	cmd := "lvchange"
-	args := []string{"-K", vgname + "/" + lvname, "-a"}
-	output := ""
-	var err error
+// isLVActive reports whether vgname/lvname is currently active, read from
+// the fifth character of lv_attr (see lvs(8), "State" field).
+func isLVActive(ctx context.Context, budget time.Duration, vgname string, lvname string) (bool, error) {
+	lvs, err := lvmreport.ListLVs(ctx, budget, vgname+"/"+lvname, "--options", "lv_attr")
+	if err != nil {
+		return false, err
+	}
+	if len(lvs) == 0 || len(lvs[0].LVAttr) < 5 {
+		return false, nil
+	}
+	return lvs[0].LVAttr[4] == 'a', nil
+}
 
-	if activate {
-		args = append(args, "y")
-	} else {
-		args = append(args, "n")
+// lvUsage returns the bytes in use of the thin logical volume vgname/lvname,
+// computed as lv_size scaled by data_percent (or lv_size itself when
+// data_percent is empty). Inodes is left zero.
+func lvUsage(ctx context.Context, budget time.Duration, vgname string, lvname string) (snapshots.Usage, error) {
+	lvs, err := lvmreport.ListLVs(ctx, budget, vgname+"/"+lvname, "--options", "lv_size,data_percent")
+	if err != nil {
+		return snapshots.Usage{}, err
+	}
+	if len(lvs) == 0 {
+		return snapshots.Usage{}, errors.Errorf("no such logical volume %s/%s", vgname, lvname)
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(lvs[0].LVSize), 10, 64)
+	if err != nil {
+		return snapshots.Usage{}, errors.Wrap(err, "failed to parse lv_size")
+	}
+
+	used := size
+	if pct := strings.TrimSpace(lvs[0].DataPercent); pct != "" {
+		dataPercent, err := strconv.ParseFloat(pct, 64)
+		if err != nil {
+			return snapshots.Usage{}, errors.Wrap(err, "failed to parse data_percent")
+		}
+		used = int64(float64(size) * dataPercent / 100)
 	}
-	output, err = runCommand(cmd, args)
-	return output, err
+
+	return snapshots.Usage{Size: used}, nil
 }
 
-func toggleactivateVG(lock sync.Mutex, vgname string, activate bool) (string, error) {
-	lock.Lock()
-	defer lock.Unlock()
+func toggleactivateVG(ctx context.Context, budget time.Duration, vgname string, activate bool) (string, error) {
 	cmd := "vgchange"
 	args := []string{"-K", vgname, "-a"}
-	output := ""
-	var err error
 
 	if activate {
 		args = append(args, "y")
 	} else {
 		args = append(args, "n")
 	}
-	output, err = runCommand(cmd, args)
-	return output, err
-}
-
-func runCommand(cmd string, args []string) (string, error) {
-	var output []byte
-	ret := 0
-	var err error
-
-	// Pass context down and log into the tool instead of this.
-	// fmt.Printf("Running command %s with args: %s\n", cmd, args)
-	for ret < retries {
-		c := exec.Command(cmd, args...)
-		c.Env = os.Environ()
-		c.SysProcAttr = &syscall.SysProcAttr{
-			Pdeathsig: syscall.SIGTERM,
-			Setpgid:   true,
-		}
-
-		output, err = c.CombinedOutput()
-		if err == nil {
-			break
-		}
-		ret++
-		time.Sleep(100000 * time.Nanosecond)
-	}
-
-	return strings.TrimSpace(string(output)), err
+	stdout, _, err := backend.Exec(ctx, budget, cmd, args...)
+	return strings.TrimSpace(string(stdout)), err
 }
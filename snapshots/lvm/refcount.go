@@ -0,0 +1,157 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvm
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	refcountBucket = []byte("lvm-refcounts")
+	optionsBucket  = []byte("lvm-options")
+)
+
+// refcountStore persists, per logical volume, the number of active
+// consumers. It backs the "activate only for the first user, deactivate
+// after the last" lifecycle: Prepare calls Acquire before activating, and
+// Remove calls Release, tearing the volume down only once the count reaches
+// zero. Mounts does not call Acquire.
+type refcountStore struct {
+	db *bolt.DB
+}
+
+// newRefcountStore opens (creating if necessary) the bbolt database at path
+// used to track lvm volume use-counts across snapshotter restarts.
+func newRefcountStore(path string) (*refcountStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open lvm refcount store")
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(refcountBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(optionsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to initialize lvm refcount bucket")
+	}
+	return &refcountStore{db: db}, nil
+}
+
+func (r *refcountStore) Close() error {
+	return r.db.Close()
+}
+
+// Acquire increments the use-count for key and returns the value after the
+// increment, so callers can tell whether they are the first consumer (1).
+func (r *refcountStore) Acquire(ctx context.Context, key string) (uint32, error) {
+	return r.adjust(key, 1)
+}
+
+// Release decrements the use-count for key and returns the value after the
+// decrement, so callers can tell whether they were the last consumer (0).
+// Releasing a key already at zero is a no-op that returns 0.
+func (r *refcountStore) Release(ctx context.Context, key string) (uint32, error) {
+	return r.adjust(key, -1)
+}
+
+// Get returns the current use-count for key without modifying it.
+func (r *refcountStore) Get(ctx context.Context, key string) (uint32, error) {
+	var count uint32
+	err := r.db.View(func(tx *bolt.Tx) error {
+		count = getCount(tx.Bucket(refcountBucket), key)
+		return nil
+	})
+	return count, err
+}
+
+// All returns every key with a non-zero use-count, for crash recovery.
+func (r *refcountStore) All(ctx context.Context) (map[string]uint32, error) {
+	counts := map[string]uint32{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(refcountBucket)
+		return b.ForEach(func(k, v []byte) error {
+			if c := binary.BigEndian.Uint32(v); c > 0 {
+				counts[string(k)] = c
+			}
+			return nil
+		})
+	})
+	return counts, err
+}
+
+func (r *refcountStore) adjust(key string, delta int) (uint32, error) {
+	var count uint32
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(refcountBucket)
+		count = getCount(b, key)
+		switch {
+		case delta > 0:
+			count++
+		case delta < 0 && count > 0:
+			count--
+		}
+		buf := make([]byte, 4)
+		binary.BigEndian.PutUint32(buf, count)
+		return b.Put([]byte(key), buf)
+	})
+	return count, err
+}
+
+// SaveOptions persists the resolved snapshotOptions for key, so that later
+// Mounts() calls (which receive no snapshots.Opt of their own) reconstruct
+// the same fstype/mount options the volume was created with.
+func (r *refcountStore) SaveOptions(ctx context.Context, key string, opts snapshotOptions) error {
+	buf, err := json.Marshal(opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal lvm snapshot options")
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(optionsBucket).Put([]byte(key), buf)
+	})
+}
+
+// LoadOptions returns the snapshotOptions previously saved for key via
+// SaveOptions, or the zero value if none were saved.
+func (r *refcountStore) LoadOptions(ctx context.Context, key string) (snapshotOptions, error) {
+	var opts snapshotOptions
+	err := r.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(optionsBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &opts)
+	})
+	return opts, err
+}
+
+func getCount(b *bolt.Bucket, key string) uint32 {
+	v := b.Get([]byte(key))
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(v)
+}
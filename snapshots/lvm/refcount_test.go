@@ -0,0 +1,130 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvm
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestRefcountStore(t *testing.T) *refcountStore {
+	t.Helper()
+	r, err := newRefcountStore(filepath.Join(t.TempDir(), "refcounts.db"))
+	if err != nil {
+		t.Fatalf("newRefcountStore: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRefcountStoreAcquireRelease(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRefcountStore(t)
+
+	count, err := r.Acquire(ctx, "cd-a")
+	if err != nil || count != 1 {
+		t.Fatalf("Acquire #1 = %d, %v; want 1, nil", count, err)
+	}
+
+	count, err = r.Acquire(ctx, "cd-a")
+	if err != nil || count != 2 {
+		t.Fatalf("Acquire #2 = %d, %v; want 2, nil", count, err)
+	}
+
+	count, err = r.Release(ctx, "cd-a")
+	if err != nil || count != 1 {
+		t.Fatalf("Release #1 = %d, %v; want 1, nil", count, err)
+	}
+
+	count, err = r.Release(ctx, "cd-a")
+	if err != nil || count != 0 {
+		t.Fatalf("Release #2 = %d, %v; want 0, nil", count, err)
+	}
+}
+
+func TestRefcountStoreReleaseAtZeroIsNoop(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRefcountStore(t)
+
+	count, err := r.Release(ctx, "cd-a")
+	if err != nil || count != 0 {
+		t.Fatalf("Release on unknown key = %d, %v; want 0, nil", count, err)
+	}
+}
+
+func TestRefcountStoreAll(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRefcountStore(t)
+
+	if _, err := r.Acquire(ctx, "cd-a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Acquire(ctx, "cd-b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Release(ctx, "cd-b"); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := r.All(ctx)
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if want := map[string]uint32{"cd-a": 1}; len(counts) != len(want) || counts["cd-a"] != want["cd-a"] {
+		t.Fatalf("All = %v; want %v (cd-b should drop out once it hits 0)", counts, want)
+	}
+}
+
+func TestRefcountStoreSaveLoadOptions(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRefcountStore(t)
+
+	want := snapshotOptions{
+		FSType:       "xfs",
+		MkfsOptions:  []string{"-K"},
+		MountOptions: []string{"discard"},
+		Size:         "20G",
+	}
+	if err := r.SaveOptions(ctx, "cd-a", want); err != nil {
+		t.Fatalf("SaveOptions: %v", err)
+	}
+
+	got, err := r.LoadOptions(ctx, "cd-a")
+	if err != nil {
+		t.Fatalf("LoadOptions: %v", err)
+	}
+	if got.FSType != want.FSType || got.Size != want.Size || len(got.MkfsOptions) != 1 || len(got.MountOptions) != 1 {
+		t.Fatalf("LoadOptions = %+v; want %+v", got, want)
+	}
+}
+
+func TestRefcountStoreLoadOptionsUnsaved(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRefcountStore(t)
+
+	got, err := r.LoadOptions(ctx, "cd-missing")
+	if err != nil {
+		t.Fatalf("LoadOptions: %v", err)
+	}
+	if !reflect.DeepEqual(got, snapshotOptions{}) {
+		t.Fatalf("LoadOptions on unsaved key = %+v; want zero value", got)
+	}
+}
@@ -0,0 +1,254 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvm
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/lvm/backend"
+	"github.com/pkg/errors"
+)
+
+// ErrInUse is returned by Remove when other consumers still hold the
+// snapshot's use-count, so the underlying logical volume was left intact.
+var ErrInUse = errors.New("lvm snapshot still in use")
+
+// Snapshotter manages container rootfs state as LVM thin logical volumes in
+// a single volume group, backed by a thin pool.
+type Snapshotter struct {
+	root   string
+	vgname string
+	lvpool string
+	config SnapshotterConfig
+
+	backend   backend.Backend
+	refcounts *refcountStore
+}
+
+// NewSnapshotter returns a Snapshotter that creates its logical volumes in
+// vgname/lvpool and keeps its bookkeeping under root.
+func NewSnapshotter(root, vgname, lvpool string, config SnapshotterConfig) (*Snapshotter, error) {
+	b, err := backend.New(config.Backend, vgname, lvpool, root, config.RetryBudget)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to construct lvm backend")
+	}
+
+	refcounts, err := newRefcountStore(filepath.Join(root, "refcounts.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshotter{
+		root:      root,
+		vgname:    vgname,
+		lvpool:    lvpool,
+		config:    config,
+		backend:   b,
+		refcounts: refcounts,
+	}
+
+	if err := s.recoverActivation(context.Background()); err != nil {
+		refcounts.Close()
+		return nil, errors.Wrap(err, "failed to recover lvm activation state")
+	}
+
+	return s, nil
+}
+
+func (s *Snapshotter) Close() error {
+	if closer, ok := s.backend.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return s.refcounts.Close()
+}
+
+func (s *Snapshotter) lvname(key string) string {
+	return "cd-" + key
+}
+
+// Prepare creates the logical volume for key from parent (or from the thin
+// pool, if parent is empty), registers it as a new consumer, and returns its
+// mount. The volume is activated when the use-count transitions from 0 to
+// 1; the snapshotter does not mount it itself. Prepare always creates the
+// volume for key first, so it does not attach to an existing one.
+func (s *Snapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+	sOpts, err := resolveInfo(s.config, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	parentLV := ""
+	if parent != "" {
+		parentLV = s.lvname(parent)
+	}
+
+	lvname := s.lvname(key)
+	if parentLV != "" {
+		if err := s.backend.CreateSnapshot(ctx, s.vgname, parentLV, lvname); err != nil {
+			return nil, err
+		}
+	} else if err := s.backend.CreateThin(ctx, s.vgname, s.lvpool, lvname, sOpts.Size); err != nil {
+		return nil, err
+	}
+
+	if err := s.refcounts.SaveOptions(ctx, lvname, sOpts); err != nil {
+		return nil, errors.Wrap(err, "failed to persist lvm snapshot options")
+	}
+
+	if err := s.acquire(ctx, lvname); err != nil {
+		return nil, err
+	}
+
+	// mkfs needs the volume activated first: the DM backend has no device
+	// node at all before Activate, and even the Exec backend's /dev symlink
+	// is not guaranteed to exist until lvchange -ay runs.
+	if parent == "" {
+		if err := formatVolume(ctx, s.config.RetryBudget, s.backend.DevicePath(s.vgname, lvname), sOpts.FSType, sOpts.MkfsOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.Mounts(ctx, key)
+}
+
+// acquire registers a new consumer of lvname, activating the underlying
+// logical volume if this is the first one (use-count 0 -> 1); it does not
+// mount it.
+// Only Prepare calls this: per snapshots.Snapshotter's contract, Mounts
+// itself is a repeatable query used to recover the mounts after Prepare
+// (e.g. across a containerd restart), not a new-consumer event, so it must
+// not bump the use-count on every call.
+func (s *Snapshotter) acquire(ctx context.Context, lvname string) error {
+	count, err := s.refcounts.Acquire(ctx, lvname)
+	if err != nil {
+		return errors.Wrap(err, "failed to acquire lvm use-count")
+	}
+
+	if count == 1 {
+		if err := s.activateAndMount(ctx, lvname); err != nil {
+			if _, rerr := s.refcounts.Release(ctx, lvname); rerr != nil {
+				log.G(ctx).WithError(rerr).Warn("failed to release lvm use-count after failed activation")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Mounts returns the mount for key's already-activated logical volume,
+// without registering a new consumer. Callers must have already called
+// Prepare (or held a mount from a previous call) for key.
+func (s *Snapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, error) {
+	lvname := s.lvname(key)
+
+	sOpts, err := s.refcounts.LoadOptions(ctx, lvname)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load lvm snapshot options")
+	}
+	return []mount.Mount{volumeMount(s.backend.DevicePath(s.vgname, lvname), sOpts)}, nil
+}
+
+// Remove releases key's use-count and, only once that reaches zero (the
+// last consumer has gone away), unmounts, deactivates and deletes the
+// underlying logical volume. If other consumers still hold it, the volume
+// is left alone and ErrInUse is returned.
+func (s *Snapshotter) Remove(ctx context.Context, key string) error {
+	lvname := s.lvname(key)
+
+	count, err := s.refcounts.Release(ctx, lvname)
+	if err != nil {
+		return errors.Wrap(err, "failed to release lvm use-count")
+	}
+	if count != 0 {
+		return errors.Wrapf(ErrInUse, "lvm volume %s still has %d consumer(s)", lvname, count)
+	}
+
+	if err := s.unmountAndDeactivate(ctx, lvname); err != nil {
+		return err
+	}
+
+	return s.backend.Remove(ctx, s.vgname, lvname)
+}
+
+func (s *Snapshotter) activateAndMount(ctx context.Context, lvname string) error {
+	if err := s.backend.Activate(ctx, s.vgname, lvname, true); err != nil {
+		return errors.Wrap(err, "failed to activate logical volume")
+	}
+	log.G(ctx).WithField("lv", lvname).Debug("activated lvm volume for first consumer")
+	return nil
+}
+
+func (s *Snapshotter) unmountAndDeactivate(ctx context.Context, lvname string) error {
+	if err := unmountDevice(ctx, s.config.RetryBudget, s.vgname, lvname); err != nil {
+		return err
+	}
+	if err := s.backend.Activate(ctx, s.vgname, lvname, false); err != nil {
+		return errors.Wrap(err, "failed to deactivate logical volume")
+	}
+	log.G(ctx).WithField("lv", lvname).Debug("deactivated lvm volume after last consumer")
+	return nil
+}
+
+// recoverActivation reconciles on-disk LV activation state against the
+// refcounts persisted from the previous run, so a crash between a use-count
+// update and its corresponding lvchange does not leave volumes inactive with
+// consumers expecting a mount. Only volumes with a non-zero use-count are
+// checked (refcountStore.All skips zero counts), so a volume left active
+// after its count dropped to zero is not deactivated here. Activation state
+// is read via lvs, so this only reflects reality
+// for the Exec backend until the DM backend reaches parity.
+func (s *Snapshotter) recoverActivation(ctx context.Context) error {
+	counts, err := s.refcounts.All(ctx)
+	if err != nil {
+		return err
+	}
+
+	for lvname, count := range counts {
+		active, err := isLVActive(ctx, s.config.RetryBudget, s.vgname, lvname)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("lv", lvname).Warn("failed to check lvm activation state during recovery")
+			continue
+		}
+		switch {
+		case count > 0 && !active:
+			if err := s.activateAndMount(ctx, lvname); err != nil {
+				return err
+			}
+		case count == 0 && active:
+			if err := s.unmountAndDeactivate(ctx, lvname); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Usage returns real usage data for key's logical volume, computed from
+// lv_size and data_percent, instead of the zero-value containerd's GC and
+// disk-usage accounting would otherwise see.
+func (s *Snapshotter) Usage(ctx context.Context, key string) (snapshots.Usage, error) {
+	return lvUsage(ctx, s.config.RetryBudget, s.vgname, s.lvname(key))
+}